@@ -0,0 +1,223 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallelHTTPReaderInOrderDelivery(t *testing.T) {
+	full := []byte("0123456789abcdefghijklmnopqrstuvwxyz") // 36 bytes
+	partSize := int64(10)
+
+	open := func(_ context.Context, offset int64) (*http.Response, error) {
+		// Make earlier offsets resolve slower than later ones, so workers
+		// complete out of order; Read must still deliver bytes in sequence.
+		time.Sleep(time.Duration(len(full)-int(offset)) * time.Millisecond)
+		return &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Status:     "206 Partial Content",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:])),
+		}, nil
+	}
+
+	rc, err := newParallelHTTPReader(int64(len(full)), open, 4, partSize)
+	if err != nil {
+		t.Fatalf("newParallelHTTPReader: %v", err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != string(full) {
+		t.Errorf("got %q, want %q", got, full)
+	}
+}
+
+func TestParallelHTTPReaderPropagatesPartError(t *testing.T) {
+	full := make([]byte, 30)
+	partSize := int64(10)
+
+	open := func(_ context.Context, offset int64) (*http.Response, error) {
+		if offset == 10 {
+			return nil, errors.New("boom")
+		}
+		end := offset + partSize
+		if end > int64(len(full)) {
+			end = int64(len(full))
+		}
+		return &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Status:     "206 Partial Content",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:end])),
+		}, nil
+	}
+
+	rc, err := newParallelHTTPReader(int64(len(full)), open, 3, partSize)
+	if err != nil {
+		t.Fatalf("newParallelHTTPReader: %v", err)
+	}
+	defer rc.Close()
+
+	_, err = io.ReadAll(rc)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("ReadAll error = %v, want one wrapping %q", err, "boom")
+	}
+}
+
+func TestParallelHTTPReaderCloseCancelsPendingOpens(t *testing.T) {
+	full := make([]byte, 100)
+	partSize := int64(10)
+
+	blocked := make(chan struct{})
+	var once sync.Once
+	open := func(ctx context.Context, offset int64) (*http.Response, error) {
+		if offset == 0 {
+			// let the first part resolve so Read has bytes to deliver
+			return &http.Response{
+				StatusCode: http.StatusPartialContent,
+				Status:     "206 Partial Content",
+				Body:       io.NopCloser(bytes.NewReader(full[:partSize])),
+			}, nil
+		}
+		once.Do(func() { close(blocked) })
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	rcIface, err := newParallelHTTPReader(int64(len(full)), open, 4, partSize)
+	if err != nil {
+		t.Fatalf("newParallelHTTPReader: %v", err)
+	}
+	rc := rcIface.(*parallelHTTPReader)
+
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a worker to block on its open call")
+	}
+
+	if err := rc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case <-rc.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not cancel the reader's context, so blocked opens would never unblock")
+	}
+}
+
+// closeTrackingBody wraps an io.Reader to report whether Close was called,
+// so tests can verify newRangeReader never leaks the probe response.
+type closeTrackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeTrackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestNewRangeReaderReusesProbeAsPart0(t *testing.T) {
+	full := []byte("0123456789abcdefghij") // 20 bytes
+	partSize := int64(10)
+	probeBody := &closeTrackingBody{Reader: bytes.NewReader(full[:partSize])}
+	probe := &http.Response{
+		StatusCode: http.StatusPartialContent,
+		Status:     "206 Partial Content",
+		Body:       probeBody,
+	}
+
+	var opens []int64
+	open := func(_ context.Context, offset int64) (*http.Response, error) {
+		opens = append(opens, offset)
+		return &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Status:     "206 Partial Content",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:])),
+		}, nil
+	}
+
+	rc, err := newRangeReader(int64(len(full)), probe, open, 2, partSize)
+	if err != nil {
+		t.Fatalf("newRangeReader: %v", err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != string(full) {
+		t.Errorf("got %q, want %q", got, full)
+	}
+	if !probeBody.closed {
+		t.Error("expected the probe response body to be closed")
+	}
+	for _, offset := range opens {
+		if offset == 0 {
+			t.Error("expected part 0 to be served from the probe response, not refetched via open")
+		}
+	}
+}
+
+func TestNewRangeReaderFallbackClosesProbe(t *testing.T) {
+	full := []byte("0123456789")
+	probeBody := &closeTrackingBody{Reader: bytes.NewReader(full)}
+	probe := &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Body:       probeBody,
+	}
+
+	open := func(_ context.Context, offset int64) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:])),
+		}, nil
+	}
+
+	rc, err := newRangeReader(int64(len(full)), probe, open, 2, 4)
+	if err != nil {
+		t.Fatalf("newRangeReader: %v", err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != string(full) {
+		t.Errorf("got %q, want %q", got, full)
+	}
+	if !probeBody.closed {
+		t.Error("expected the probe response body to be closed on the fallback path")
+	}
+}
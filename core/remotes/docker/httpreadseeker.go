@@ -18,8 +18,14 @@ package docker
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/containerd/errdefs"
 	"github.com/containerd/log"
@@ -28,20 +34,189 @@ import (
 const maxRetry = 3
 
 type httpReadSeeker struct {
-	size   int64
 	offset int64
 	rc     io.ReadCloser
-	open   func(offset int64) (io.ReadCloser, error)
+	open   func(offset int64) (*http.Response, error)
 	closed bool
 
+	// mu guards size and the buffered-range cache below. Read, Seek, and
+	// Close are only ever called sequentially from a single goroutine like
+	// any io.Reader, but io.ReaderAt requires ReadAt to be safe to call
+	// concurrently (the motivating use case: parallel TOC/footer lookups
+	// over the same blob), and ReadAt shares both with the sequential path.
+	mu sync.Mutex
+
+	size int64
+
+	// maxBufferedRangeBytes enables buffered ReadAt: on a cache miss, ReadAt
+	// fetches this many bytes (aligned to offset) instead of exactly the
+	// requested length, and serves later ReadAt calls out of the buffer
+	// when they fall inside its extent. Zero (the default for a
+	// httpReadSeeker built without withBufferedReadAt) disables buffering;
+	// withBufferedReadAt itself always enables it, so there is no way to
+	// pass 0 through that option to mean "disabled".
+	maxBufferedRangeBytes int64
+	rangeBuf              []byte
+	rangeBufStart         int64
+	rangeBufEnd           int64
+
+	// errorHandler, when set, translates a non-2xx response into a typed
+	// error (e.g. errdefs.ErrNotFound) before the body is discarded.
+	errorHandler func(*http.Response) error
+
+	retryPolicy RetryPolicy
+
 	errsWithNoProgress int
 }
 
-func newHTTPReadSeeker(size int64, open func(offset int64) (io.ReadCloser, error)) (io.ReadCloser, error) {
-	return &httpReadSeeker{
-		size: size,
-		open: open,
-	}, nil
+// RetryPolicy controls how httpReadSeeker reconnects after a transient read
+// error.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of reconnect attempts made for a
+	// given offset before giving up and returning the error to the caller.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. Zero means retry
+	// immediately.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponentially increasing delay between retries.
+	MaxBackoff time.Duration
+	// ShouldRetry reports whether err, encountered on the given attempt
+	// (starting at 1), should trigger a reconnect. attempt resets whenever
+	// a read makes progress.
+	ShouldRetry func(err error, attempt int) bool
+}
+
+// defaultRetryPolicy preserves the seeker's historical behavior: only
+// io.ErrUnexpectedEOF is retried, up to maxRetry times, with no backoff.
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: maxRetry,
+		ShouldRetry: func(err error, _ int) bool {
+			return errors.Is(err, io.ErrUnexpectedEOF)
+		},
+	}
+}
+
+// RetryAfterError wraps Err with a server-provided delay to honor before the
+// next retry attempt, typically surfaced from a 429/503 response by an
+// errorHandler.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string { return e.Err.Error() }
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
+// backoff returns the delay before the given retry attempt (1-indexed),
+// doubling from InitialBackoff up to MaxBackoff.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.InitialBackoff <= 0 {
+		return 0
+	}
+	d := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// defaultReadAtWindow is the buffered ReadAt window used when
+// withBufferedReadAt is passed a size of 0.
+const defaultReadAtWindow = 64 * 1024
+
+// httpReadSeekerOpt configures a httpReadSeeker constructed by
+// newHTTPReadSeeker.
+type httpReadSeekerOpt func(*httpReadSeeker)
+
+// withErrorHandler sets a callback that the seeker invokes whenever open
+// returns a non-2xx response. This lets the seeker own the response
+// lifecycle, closing the body on failure and surfacing a well-typed error
+// instead of requiring open to consume the body itself.
+func withErrorHandler(fn func(*http.Response) error) httpReadSeekerOpt {
+	return func(hrs *httpReadSeeker) {
+		hrs.errorHandler = fn
+	}
+}
+
+// withBufferedReadAt enables buffered ReadAt with the given window size,
+// coalescing small random reads (e.g. TOC lookups over a blob) into fewer
+// HTTP requests. A window of 0 uses defaultReadAtWindow; callers that want
+// buffering disabled should not pass this option at all, since calling it
+// always enables buffering regardless of the window value given.
+func withBufferedReadAt(window int64) httpReadSeekerOpt {
+	if window <= 0 {
+		window = defaultReadAtWindow
+	}
+	return func(hrs *httpReadSeeker) {
+		hrs.maxBufferedRangeBytes = window
+	}
+}
+
+// withRetryPolicy overrides the default retry policy, widening the
+// retriable-error set or adding backoff between reconnect attempts. A policy
+// with a nil ShouldRetry falls back to the default's, so callers that only
+// mean to tune MaxAttempts/backoff don't have to restate it.
+func withRetryPolicy(p RetryPolicy) httpReadSeekerOpt {
+	if p.ShouldRetry == nil {
+		p.ShouldRetry = defaultRetryPolicy().ShouldRetry
+	}
+	return func(hrs *httpReadSeeker) {
+		hrs.retryPolicy = p
+	}
+}
+
+// newHTTPReadSeeker returns a seekable reader over the content served by
+// open. If size is unknown, pass -1 and it will be populated from the
+// Content-Length (or Content-Range total, for 206 responses) of the first
+// successful response, letting callers skip an upfront HEAD/Stat request.
+func newHTTPReadSeeker(size int64, open func(offset int64) (*http.Response, error), opts ...httpReadSeekerOpt) (io.ReadCloser, error) {
+	hrs := &httpReadSeeker{
+		size:        size,
+		open:        open,
+		retryPolicy: defaultRetryPolicy(),
+	}
+	for _, opt := range opts {
+		opt(hrs)
+	}
+	return hrs, nil
+}
+
+// handleErrorResponse closes resp.Body and returns the error for a non-2xx
+// response, using errorHandler to produce a typed error when set.
+func (hrs *httpReadSeeker) handleErrorResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	if hrs.errorHandler != nil {
+		if err := hrs.errorHandler(resp); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("httpReadSeeker: unexpected status code %v", resp.Status)
+}
+
+// drainBeforeCloseLimit bounds how much of a response body closeBody will
+// read before giving up and closing anyway.
+const drainBeforeCloseLimit = 2 << 10 // 2 KiB
+
+// closeBody closes rc, first attempting to drain up to remaining bytes of
+// unread body when that is within drainBeforeCloseLimit. Under HTTP/2,
+// closing a body before it reaches EOF can trigger a stream reset that
+// registries and proxies surface as noisy RST_STREAM errors, and on some
+// intermediaries prevents the underlying connection from being reused.
+// remaining should be -1 when the amount left unread is unknown or large.
+func closeBody(rc io.ReadCloser, remaining int64) error {
+	if remaining >= 0 && remaining <= drainBeforeCloseLimit {
+		io.CopyN(io.Discard, rc, remaining)
+	}
+	return rc.Close()
 }
 
 func (hrs *httpReadSeeker) Read(p []byte) (n int, err error) {
@@ -51,7 +226,14 @@ func (hrs *httpReadSeeker) Read(p []byte) (n int, err error) {
 
 	rd, err := hrs.reader()
 	if err != nil {
-		return 0, err
+		// The initial open can fail the same transient ways a mid-stream
+		// read can (a dropped connection, a 429/503 the errorHandler mapped
+		// to a *RetryAfterError), so it goes through the same retry policy
+		// rather than failing the caller on the first attempt.
+		rd, err = hrs.retryReopen(err, true)
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	n, err = rd.Read(p)
@@ -59,45 +241,112 @@ func (hrs *httpReadSeeker) Read(p []byte) (n int, err error) {
 	if n > 0 || err == nil {
 		hrs.errsWithNoProgress = 0
 	}
-	switch err {
-	case io.ErrUnexpectedEOF:
-		// connection closed unexpectedly. try reconnecting.
-		if n == 0 {
-			hrs.errsWithNoProgress++
-			if hrs.errsWithNoProgress > maxRetry {
-				return // too many retries for this offset with no progress
+	switch {
+	case err == nil:
+	case err == io.EOF:
+		// The CRI's imagePullProgressTimeout relies on responseBody.Close to
+		// update the process monitor's status. If the err is io.EOF, close
+		// the connection since there is no more available data.
+		if hrs.rc != nil {
+			if clsErr := closeBody(hrs.rc, hrs.remainingInBody()); clsErr != nil {
+				log.L.WithError(clsErr).Error("httpReadSeeker: failed to close ReadCloser after io.EOF")
 			}
+			hrs.rc = nil
 		}
+	default:
+		// connection closed unexpectedly (or another error the policy
+		// considers transient). try reconnecting.
 		if hrs.rc != nil {
-			if clsErr := hrs.rc.Close(); clsErr != nil {
+			if clsErr := closeBody(hrs.rc, hrs.remainingInBody()); clsErr != nil {
 				log.L.WithError(clsErr).Error("httpReadSeeker: failed to close ReadCloser")
 			}
 			hrs.rc = nil
 		}
-		if _, err2 := hrs.reader(); err2 == nil {
+
+		if _, err2 := hrs.retryReopen(err, n == 0); err2 == nil {
 			return n, nil
 		}
-	case io.EOF:
-		// The CRI's imagePullProgressTimeout relies on responseBody.Close to
-		// update the process monitor's status. If the err is io.EOF, close
-		// the connection since there is no more available data.
-		if hrs.rc != nil {
-			if clsErr := hrs.rc.Close(); clsErr != nil {
-				log.L.WithError(clsErr).Error("httpReadSeeker: failed to close ReadCloser after io.EOF")
-			}
-			hrs.rc = nil
-		}
 	}
 	return
 }
 
+// retryReopen attempts to reconnect after err by honoring retryPolicy:
+// ShouldRetry decides whether err is retriable at all, countsAsNoProgress
+// tracks errsWithNoProgress against MaxAttempts (the caller passes false
+// when a partial read before err means the attempt made progress), and the
+// resulting wait is either the server-provided RetryAfterError delay or the
+// policy's backoff. It returns err unchanged when no retry is attempted, and
+// the error from the retry's own reader() call when that call fails.
+func (hrs *httpReadSeeker) retryReopen(err error, countsAsNoProgress bool) (io.Reader, error) {
+	if !hrs.retryPolicy.ShouldRetry(err, hrs.errsWithNoProgress+1) {
+		return nil, err
+	}
+	if countsAsNoProgress {
+		hrs.errsWithNoProgress++
+		if hrs.errsWithNoProgress > hrs.retryPolicy.MaxAttempts {
+			return nil, err // too many retries for this offset with no progress
+		}
+	}
+
+	if wait, ok := retryAfter(err); ok {
+		time.Sleep(wait)
+	} else if d := hrs.retryPolicy.backoff(hrs.errsWithNoProgress); d > 0 {
+		time.Sleep(d)
+	}
+
+	return hrs.reader()
+}
+
+// retryAfter reports the delay a RetryAfterError in err's chain asks the
+// caller to wait before the next retry.
+func retryAfter(err error) (time.Duration, bool) {
+	var raErr *RetryAfterError
+	if errors.As(err, &raErr) {
+		return raErr.After, true
+	}
+	return 0, false
+}
+
+// remainingInBody reports how many bytes are left unread in the current
+// response body, or -1 if the size is unknown. hrs.rc always spans
+// [hrs.offset, hrs.size) since open requests an unbounded range starting at
+// the given offset.
+func (hrs *httpReadSeeker) remainingInBody() int64 {
+	size := hrs.getSize()
+	if size == -1 {
+		return -1
+	}
+	return size - hrs.offset
+}
+
+// getSize returns the seeker's currently known content size, or -1 if it is
+// still unknown. Safe to call concurrently, including from concurrent
+// ReadAt callers.
+func (hrs *httpReadSeeker) getSize() int64 {
+	hrs.mu.Lock()
+	defer hrs.mu.Unlock()
+	return hrs.size
+}
+
+// setSizeIfUnknown records sz as the content size the first time it becomes
+// known. Concurrent ReadAt callers may each derive sz from their own
+// response and race to call this; the first one in wins and later calls are
+// no-ops, which is fine since every caller observed the same content.
+func (hrs *httpReadSeeker) setSizeIfUnknown(sz int64) {
+	hrs.mu.Lock()
+	if hrs.size == -1 {
+		hrs.size = sz
+	}
+	hrs.mu.Unlock()
+}
+
 func (hrs *httpReadSeeker) Close() error {
 	if hrs.closed {
 		return nil
 	}
 	hrs.closed = true
 	if hrs.rc != nil {
-		return hrs.rc.Close()
+		return closeBody(hrs.rc, hrs.remainingInBody())
 	}
 
 	return nil
@@ -112,7 +361,7 @@ func (hrs *httpReadSeeker) ReadAt(p []byte, offset int64) (n int, err error) {
 		return 0, fmt.Errorf("httpReadSeeker.ReadAt: negative offset: %w", errdefs.ErrInvalidArgument)
 	}
 
-	if hrs.size != -1 && offset >= hrs.size {
+	if size := hrs.getSize(); size != -1 && offset >= size {
 		return 0, io.EOF
 	}
 
@@ -120,17 +369,121 @@ func (hrs *httpReadSeeker) ReadAt(p []byte, offset int64) (n int, err error) {
 		return 0, fmt.Errorf("httpReadSeeker.ReadAt: cannot open: %w", errdefs.ErrNotImplemented)
 	}
 
-	rc, err := hrs.open(offset)
+	if hrs.maxBufferedRangeBytes > 0 {
+		return hrs.readAtBuffered(p, offset)
+	}
+
+	resp, err := hrs.open(offset)
 	if err != nil {
 		return 0, fmt.Errorf("httpReadSeeker.ReadAt: failed to open at offset %d: %w", offset, err)
 	}
-	defer func() {
-		if closeErr := rc.Close(); closeErr != nil {
-			log.L.WithError(closeErr).Error("httpReadSeeker.ReadAt: failed to close ReadCloser")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, hrs.handleErrorResponse(resp)
+	}
+
+	if sz, ok := sizeFromResponse(resp, offset); ok {
+		hrs.setSizeIfUnknown(sz)
+	}
+
+	n, err = io.ReadFull(resp.Body, p)
+
+	// open requests an unbounded range starting at offset, so the body may
+	// hold much more than p past this point; drain and close rather than
+	// resetting the connection mid-stream (see closeBody).
+	remaining := int64(-1)
+	if size := hrs.getSize(); size != -1 {
+		remaining = size - (offset + int64(n))
+	}
+	if closeErr := closeBody(resp.Body, remaining); closeErr != nil {
+		log.L.WithError(closeErr).Error("httpReadSeeker.ReadAt: failed to close ReadCloser")
+	}
+
+	return n, err
+}
+
+// readAtBuffered serves p from the cached range window when possible,
+// otherwise fetches a fresh window of hrs.maxBufferedRangeBytes starting at
+// offset and caches it before serving p from it.
+func (hrs *httpReadSeeker) readAtBuffered(p []byte, offset int64) (int, error) {
+	if n, ok := hrs.readFromRangeCache(p, offset); ok {
+		return n, nil
+	}
+
+	size := hrs.getSize()
+
+	window := hrs.maxBufferedRangeBytes
+	if need := int64(len(p)); need > window {
+		window = need
+	}
+	if size != -1 && offset+window > size {
+		window = size - offset
+	}
+
+	resp, err := hrs.open(offset)
+	if err != nil {
+		return 0, fmt.Errorf("httpReadSeeker.ReadAt: failed to open at offset %d: %w", offset, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, hrs.handleErrorResponse(resp)
+	}
+
+	if size == -1 {
+		if sz, ok := sizeFromResponse(resp, offset); ok {
+			hrs.setSizeIfUnknown(sz)
+			size = sz
 		}
-	}()
+	}
+
+	buf := make([]byte, window)
+	read, err := io.ReadFull(resp.Body, buf)
+
+	// open requests an unbounded range starting at offset, so the body may
+	// hold much more than window past this point; drain and close rather
+	// than resetting the connection mid-stream (see closeBody). Buffered
+	// ReadAt calls are frequently TOC/footer lookups near the end of the
+	// blob, where the drain-when-small-remainder case applies often.
+	remaining := int64(-1)
+	if size != -1 {
+		remaining = size - (offset + int64(read))
+	}
+	if closeErr := closeBody(resp.Body, remaining); closeErr != nil {
+		log.L.WithError(closeErr).Error("httpReadSeeker.ReadAt: failed to close ReadCloser")
+	}
+
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:read]
+	rangeBufEnd := offset + int64(read)
+
+	hrs.mu.Lock()
+	hrs.rangeBuf = buf
+	hrs.rangeBufStart = offset
+	hrs.rangeBufEnd = rangeBufEnd
+	hrs.mu.Unlock()
+
+	if offset+int64(len(p)) > rangeBufEnd {
+		n := copy(p, buf)
+		if n < len(p) {
+			return n, io.ErrUnexpectedEOF
+		}
+		return n, nil
+	}
+
+	return copy(p, buf), nil
+}
 
-	return io.ReadFull(rc, p)
+// readFromRangeCache serves p out of the cached range window when offset and
+// len(p) fall entirely inside it, reporting ok=false on a cache miss. Safe to
+// call concurrently with other ReadAt callers and with the window being
+// replaced underneath it.
+func (hrs *httpReadSeeker) readFromRangeCache(p []byte, offset int64) (int, bool) {
+	hrs.mu.Lock()
+	defer hrs.mu.Unlock()
+	if hrs.rangeBuf != nil && offset >= hrs.rangeBufStart && offset+int64(len(p)) <= hrs.rangeBufEnd {
+		return copy(p, hrs.rangeBuf[offset-hrs.rangeBufStart:]), true
+	}
+	return 0, false
 }
 
 func (hrs *httpReadSeeker) Seek(offset int64, whence int) (int64, error) {
@@ -145,10 +498,11 @@ func (hrs *httpReadSeeker) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		abs += offset
 	case io.SeekEnd:
-		if hrs.size == -1 {
+		size := hrs.getSize()
+		if size == -1 {
 			return 0, fmt.Errorf("Fetcher.Seek: unknown size, cannot seek from end: %w", errdefs.ErrUnavailable)
 		}
-		abs = hrs.size + offset
+		abs = size + offset
 	default:
 		return 0, fmt.Errorf("Fetcher.Seek: invalid whence: %w", errdefs.ErrInvalidArgument)
 	}
@@ -159,13 +513,19 @@ func (hrs *httpReadSeeker) Seek(offset int64, whence int) (int64, error) {
 
 	if abs != hrs.offset {
 		if hrs.rc != nil {
-			if err := hrs.rc.Close(); err != nil {
+			if err := closeBody(hrs.rc, hrs.remainingInBody()); err != nil {
 				log.L.WithError(err).Error("Fetcher.Seek: failed to close ReadCloser")
 			}
 
 			hrs.rc = nil
 		}
 
+		// The buffered ReadAt window is keyed off absolute offsets that have
+		// no relation to the new sequential cursor.
+		hrs.mu.Lock()
+		hrs.rangeBuf = nil
+		hrs.mu.Unlock()
+
 		hrs.offset = abs
 	}
 
@@ -177,24 +537,32 @@ func (hrs *httpReadSeeker) reader() (io.Reader, error) {
 		return hrs.rc, nil
 	}
 
-	if hrs.size == -1 || hrs.offset < hrs.size {
+	if size := hrs.getSize(); size == -1 || hrs.offset < size {
 		// only try to reopen the body request if we are seeking to a value
 		// less than the actual size.
 		if hrs.open == nil {
 			return nil, fmt.Errorf("cannot open: %w", errdefs.ErrNotImplemented)
 		}
 
-		rc, err := hrs.open(hrs.offset)
+		resp, err := hrs.open(hrs.offset)
 		if err != nil {
 			return nil, fmt.Errorf("httpReadSeeker: failed open: %w", err)
 		}
 
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return nil, hrs.handleErrorResponse(resp)
+		}
+
+		if sz, ok := sizeFromResponse(resp, hrs.offset); ok {
+			hrs.setSizeIfUnknown(sz)
+		}
+
 		if hrs.rc != nil {
 			if err := hrs.rc.Close(); err != nil {
 				log.L.WithError(err).Error("httpReadSeeker: failed to close ReadCloser")
 			}
 		}
-		hrs.rc = rc
+		hrs.rc = resp.Body
 	} else {
 		// There is an edge case here where offset == size of the content. If
 		// we seek, we will probably get an error for content that cannot be
@@ -207,3 +575,39 @@ func (hrs *httpReadSeeker) reader() (io.Reader, error) {
 
 	return hrs.rc, nil
 }
+
+// sizeFromResponse derives the total content size from resp, the response to
+// a request made at requestOffset. It reports ok=false when the size cannot
+// be determined from the available headers.
+func sizeFromResponse(resp *http.Response, requestOffset int64) (size int64, ok bool) {
+	if resp.StatusCode == http.StatusPartialContent {
+		if total, ok := parseContentRangeTotal(resp.Header.Get("Content-Range")); ok {
+			return total, true
+		}
+		return 0, false
+	}
+
+	// Content-Length only reflects the total size when the request was not
+	// itself an offset into the content.
+	if requestOffset == 0 && resp.ContentLength >= 0 {
+		return resp.ContentLength, true
+	}
+
+	return 0, false
+}
+
+// parseContentRangeTotal parses the total size out of a "Content-Range:
+// bytes start-end/total" header value.
+func parseContentRangeTotal(v string) (int64, bool) {
+	i := strings.LastIndexByte(v, '/')
+	if i < 0 || i == len(v)-1 {
+		return 0, false
+	}
+
+	total, err := strconv.ParseInt(v[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return total, true
+}
@@ -0,0 +1,98 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package docker
+
+import (
+	"io"
+	"testing"
+)
+
+// trackingReadCloser records how many bytes were read from it before Close,
+// so tests can tell whether closeBody drained the body or closed it as-is.
+type trackingReadCloser struct {
+	io.Reader
+	read   int
+	closed bool
+}
+
+func (b *trackingReadCloser) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *trackingReadCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCloseBodyDrainsAtTheLimit(t *testing.T) {
+	data := make([]byte, drainBeforeCloseLimit)
+	rc := &trackingReadCloser{Reader: io.LimitReader(newRepeatingReader(), int64(len(data)))}
+
+	if err := closeBody(rc, drainBeforeCloseLimit); err != nil {
+		t.Fatalf("closeBody: %v", err)
+	}
+	if !rc.closed {
+		t.Fatal("expected Close to be called")
+	}
+	if rc.read != drainBeforeCloseLimit {
+		t.Errorf("read %d bytes, want the body drained at exactly the limit (%d)", rc.read, drainBeforeCloseLimit)
+	}
+}
+
+func TestCloseBodySkipsDrainPastTheLimit(t *testing.T) {
+	rc := &trackingReadCloser{Reader: newRepeatingReader()}
+
+	if err := closeBody(rc, drainBeforeCloseLimit+1); err != nil {
+		t.Fatalf("closeBody: %v", err)
+	}
+	if !rc.closed {
+		t.Fatal("expected Close to be called")
+	}
+	if rc.read != 0 {
+		t.Errorf("read %d bytes, want 0: remaining exceeds drainBeforeCloseLimit so closeBody should skip draining", rc.read)
+	}
+}
+
+func TestCloseBodySkipsDrainWhenRemainingUnknown(t *testing.T) {
+	rc := &trackingReadCloser{Reader: newRepeatingReader()}
+
+	if err := closeBody(rc, -1); err != nil {
+		t.Fatalf("closeBody: %v", err)
+	}
+	if !rc.closed {
+		t.Fatal("expected Close to be called")
+	}
+	if rc.read != 0 {
+		t.Errorf("read %d bytes, want 0: an unknown remaining size should skip draining", rc.read)
+	}
+}
+
+// repeatingReader is an io.Reader with no end, standing in for a live
+// response body so tests can distinguish "drained remaining bytes" from
+// "would have blocked forever reading an unbounded body".
+type repeatingReader struct{}
+
+func newRepeatingReader() *repeatingReader { return &repeatingReader{} }
+
+func (*repeatingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
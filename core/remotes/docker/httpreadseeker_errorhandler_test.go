@@ -0,0 +1,93 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/containerd/errdefs"
+)
+
+// closeCountingBody counts Close calls so tests can assert
+// handleErrorResponse always releases the response body.
+type closeCountingBody struct {
+	io.Reader
+	closes int
+}
+
+func (b *closeCountingBody) Close() error {
+	b.closes++
+	return nil
+}
+
+func TestHandleErrorResponseWithoutErrorHandler(t *testing.T) {
+	hrs := &httpReadSeeker{}
+	body := &closeCountingBody{Reader: bytes.NewReader(nil)}
+	resp := &http.Response{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error", Body: body}
+
+	err := hrs.handleErrorResponse(resp)
+	if err == nil {
+		t.Fatal("expected an error for a non-2xx response")
+	}
+	if body.closes != 1 {
+		t.Errorf("Body closed %d times, want 1", body.closes)
+	}
+}
+
+func TestHandleErrorResponseUsesErrorHandler(t *testing.T) {
+	wantErr := errdefs.ErrNotFound
+	hrs := &httpReadSeeker{}
+	withErrorHandler(func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("errorHandler got status %d, want 404", resp.StatusCode)
+		}
+		return wantErr
+	})(hrs)
+
+	body := &closeCountingBody{Reader: bytes.NewReader(nil)}
+	resp := &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: body}
+
+	err := hrs.handleErrorResponse(resp)
+	if !errors.Is(err, wantErr) {
+		t.Errorf("err = %v, want it to wrap %v", err, wantErr)
+	}
+	if body.closes != 1 {
+		t.Errorf("Body closed %d times, want 1", body.closes)
+	}
+}
+
+func TestHandleErrorResponseErrorHandlerDeclines(t *testing.T) {
+	hrs := &httpReadSeeker{}
+	withErrorHandler(func(*http.Response) error {
+		return nil // not an error this handler recognizes; fall back to the generic error
+	})(hrs)
+
+	body := &closeCountingBody{Reader: bytes.NewReader(nil)}
+	resp := &http.Response{StatusCode: http.StatusTeapot, Status: "418 I'm a teapot", Body: body}
+
+	err := hrs.handleErrorResponse(resp)
+	if err == nil {
+		t.Fatal("expected a generic error when errorHandler declines to translate the response")
+	}
+	if body.closes != 1 {
+		t.Errorf("Body closed %d times, want 1", body.closes)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package docker
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestReadAtBufferedCacheHitAndMiss(t *testing.T) {
+	full := []byte("0123456789abcdefghij") // 20 bytes
+	var opens []int64
+	open := func(offset int64) (*http.Response, error) {
+		opens = append(opens, offset)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:])),
+		}, nil
+	}
+
+	rsIface, err := newHTTPReadSeeker(int64(len(full)), open, withBufferedReadAt(8))
+	if err != nil {
+		t.Fatalf("newHTTPReadSeeker: %v", err)
+	}
+	hrs := rsIface.(*httpReadSeeker)
+
+	p := make([]byte, 4)
+
+	if _, err := hrs.ReadAt(p, 0); err != nil {
+		t.Fatalf("ReadAt(0): %v", err)
+	}
+	if string(p) != "0123" {
+		t.Fatalf("ReadAt(0) = %q, want %q", p, "0123")
+	}
+	if len(opens) != 1 {
+		t.Fatalf("opens = %d, want 1", len(opens))
+	}
+
+	// offset 4, len 4 falls entirely inside the cached [0,8) window: no new open.
+	if _, err := hrs.ReadAt(p, 4); err != nil {
+		t.Fatalf("ReadAt(4): %v", err)
+	}
+	if string(p) != "4567" {
+		t.Fatalf("ReadAt(4) = %q, want %q", p, "4567")
+	}
+	if len(opens) != 1 {
+		t.Fatalf("opens after cache hit = %d, want 1", len(opens))
+	}
+
+	// offset 8 falls outside the cached window: must refetch.
+	if _, err := hrs.ReadAt(p, 8); err != nil {
+		t.Fatalf("ReadAt(8): %v", err)
+	}
+	if string(p) != "89ab" {
+		t.Fatalf("ReadAt(8) = %q, want %q", p, "89ab")
+	}
+	if len(opens) != 2 {
+		t.Fatalf("opens after cache miss = %d, want 2", len(opens))
+	}
+}
+
+func TestReadAtBufferedInvalidatedBySeek(t *testing.T) {
+	full := []byte("0123456789")
+	open := func(offset int64) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:])),
+		}, nil
+	}
+
+	rsIface, err := newHTTPReadSeeker(int64(len(full)), open, withBufferedReadAt(8))
+	if err != nil {
+		t.Fatalf("newHTTPReadSeeker: %v", err)
+	}
+	hrs := rsIface.(*httpReadSeeker)
+
+	p := make([]byte, 2)
+	if _, err := hrs.ReadAt(p, 0); err != nil {
+		t.Fatalf("ReadAt(0): %v", err)
+	}
+	if hrs.rangeBuf == nil {
+		t.Fatal("expected the range buffer to be populated after ReadAt")
+	}
+
+	if _, err := hrs.Seek(3, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if hrs.rangeBuf != nil {
+		t.Fatal("expected Seek to invalidate the buffered range window")
+	}
+}
+
+// TestReadAtBufferedConcurrent exercises many concurrent ReadAt callers
+// against a shared, buffered httpReadSeeker: io.ReaderAt requires ReadAt to
+// be safe to call concurrently (the motivating use case is parallel
+// TOC/footer lookups over the same blob), and readAtBuffered's cache window
+// is shared mutable state. Run with -race.
+func TestReadAtBufferedConcurrent(t *testing.T) {
+	full := make([]byte, 4096)
+	for i := range full {
+		full[i] = byte(i)
+	}
+	open := func(offset int64) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:])),
+		}, nil
+	}
+
+	rsIface, err := newHTTPReadSeeker(int64(len(full)), open, withBufferedReadAt(64))
+	if err != nil {
+		t.Fatalf("newHTTPReadSeeker: %v", err)
+	}
+	hrs := rsIface.(*httpReadSeeker)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		offset := int64(g * 7 % (len(full) - 16))
+		wg.Add(1)
+		go func(offset int64) {
+			defer wg.Done()
+			p := make([]byte, 16)
+			if _, err := hrs.ReadAt(p, offset); err != nil {
+				t.Errorf("ReadAt(%d): %v", offset, err)
+				return
+			}
+			want := full[offset : offset+16]
+			if !bytes.Equal(p, want) {
+				t.Errorf("ReadAt(%d) = %v, want %v", offset, p, want)
+			}
+		}(offset)
+	}
+	wg.Wait()
+}
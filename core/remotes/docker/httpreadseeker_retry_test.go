@@ -0,0 +1,171 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 350 * time.Millisecond}
+	for _, tc := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 350 * time.Millisecond}, // would be 400ms, capped by MaxBackoff
+		{4, 350 * time.Millisecond},
+	} {
+		if got := p.backoff(tc.attempt); got != tc.want {
+			t.Errorf("backoff(%d) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffZeroInitial(t *testing.T) {
+	var p RetryPolicy
+	if got := p.backoff(5); got != 0 {
+		t.Errorf("backoff(5) = %v, want 0 with no InitialBackoff", got)
+	}
+}
+
+func TestWithRetryPolicyDefaultsShouldRetry(t *testing.T) {
+	hrs := &httpReadSeeker{}
+	withRetryPolicy(RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond})(hrs)
+
+	if hrs.retryPolicy.MaxAttempts != 5 {
+		t.Errorf("MaxAttempts = %d, want 5", hrs.retryPolicy.MaxAttempts)
+	}
+	if hrs.retryPolicy.ShouldRetry == nil {
+		t.Fatal("ShouldRetry should default when the caller omits it")
+	}
+	if !hrs.retryPolicy.ShouldRetry(io.ErrUnexpectedEOF, 1) {
+		t.Error("defaulted ShouldRetry should retry io.ErrUnexpectedEOF, matching defaultRetryPolicy")
+	}
+}
+
+// shortReader yields data and then io.ErrUnexpectedEOF instead of io.EOF,
+// simulating a connection that drops mid-stream.
+type shortReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *shortReader) Read(p []byte) (int, error) {
+	if r.pos >= len(r.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+func TestHTTPReadSeekerReconnectsOnUnexpectedEOF(t *testing.T) {
+	full := []byte("hello world")
+	var opens []int64
+	open := func(offset int64) (*http.Response, error) {
+		opens = append(opens, offset)
+		if len(opens) == 1 {
+			// first connection drops after 2 bytes
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Status:     "200 OK",
+				Body:       io.NopCloser(&shortReader{data: full[offset : offset+2]}),
+			}, nil
+		}
+		// the reconnect succeeds and runs to a normal io.EOF
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:])),
+		}, nil
+	}
+
+	rc, err := newHTTPReadSeeker(int64(len(full)), open)
+	if err != nil {
+		t.Fatalf("newHTTPReadSeeker: %v", err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != string(full) {
+		t.Errorf("got %q, want %q", got, full)
+	}
+	if len(opens) != 2 {
+		t.Errorf("open called %d times, want 2 (initial + one reconnect)", len(opens))
+	}
+}
+
+// TestHTTPReadSeekerRetriesOpenTimeError verifies that a transient error from
+// the initial open (not just a mid-stream read) goes through the same
+// RetryPolicy: a 503 mapped by errorHandler to a *RetryAfterError fails the
+// very first hrs.reader() call inside Read, and with an always-true
+// ShouldRetry that must still be retried rather than surfaced immediately.
+func TestHTTPReadSeekerRetriesOpenTimeError(t *testing.T) {
+	full := []byte("hello world")
+	var opens int
+
+	open := func(offset int64) (*http.Response, error) {
+		opens++
+		if opens == 1 {
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Status:     "503 Service Unavailable",
+				Body:       io.NopCloser(bytes.NewReader(nil)),
+			}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(bytes.NewReader(full[offset:])),
+		}, nil
+	}
+
+	rc, err := newHTTPReadSeeker(int64(len(full)), open,
+		withErrorHandler(func(resp *http.Response) error {
+			return &RetryAfterError{Err: fmt.Errorf("status %v", resp.Status)}
+		}),
+		withRetryPolicy(RetryPolicy{
+			MaxAttempts: 3,
+			ShouldRetry: func(error, int) bool { return true },
+		}),
+	)
+	if err != nil {
+		t.Fatalf("newHTTPReadSeeker: %v", err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != string(full) {
+		t.Errorf("got %q, want %q", got, full)
+	}
+	if opens != 2 {
+		t.Errorf("open called %d times, want 2 (failed open + retry)", opens)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package docker
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseContentRangeTotal(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"well formed", "bytes 0-99/200", 200, true},
+		{"missing slash", "bytes 0-99", 0, false},
+		{"trailing slash with no total", "bytes 0-99/", 0, false},
+		{"non-numeric total", "bytes 0-99/*", 0, false},
+		{"empty", "", 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseContentRangeTotal(tc.value)
+			if ok != tc.ok {
+				t.Fatalf("ok = %v, want %v", ok, tc.ok)
+			}
+			if ok && got != tc.want {
+				t.Errorf("total = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSizeFromResponse(t *testing.T) {
+	t.Run("206 with Content-Range", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Header:     http.Header{"Content-Range": []string{"bytes 10-19/100"}},
+		}
+		size, ok := sizeFromResponse(resp, 10)
+		if !ok || size != 100 {
+			t.Fatalf("sizeFromResponse = (%d, %v), want (100, true)", size, ok)
+		}
+	})
+
+	t.Run("206 with unparsable Content-Range", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Header:     http.Header{"Content-Range": []string{"bytes 10-19/*"}},
+		}
+		if _, ok := sizeFromResponse(resp, 10); ok {
+			t.Fatal("expected ok=false for a Content-Range with a non-numeric total")
+		}
+	})
+
+	t.Run("200 at offset 0 uses Content-Length", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusOK, ContentLength: 42}
+		size, ok := sizeFromResponse(resp, 0)
+		if !ok || size != 42 {
+			t.Fatalf("sizeFromResponse = (%d, %v), want (42, true)", size, ok)
+		}
+	})
+
+	t.Run("200 at a nonzero offset cannot be trusted as total size", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusOK, ContentLength: 42}
+		if _, ok := sizeFromResponse(resp, 10); ok {
+			t.Fatal("expected ok=false: Content-Length of a non-offset-0 200 response isn't the total size")
+		}
+	})
+
+	t.Run("200 with unknown Content-Length", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusOK, ContentLength: -1}
+		if _, ok := sizeFromResponse(resp, 0); ok {
+			t.Fatal("expected ok=false when Content-Length is unknown")
+		}
+	})
+}